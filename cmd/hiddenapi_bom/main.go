@@ -0,0 +1,133 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// hiddenapi_bom reads a tab-separated manifest of
+// "module\tjar\tencodedDex\tflagsCSV" records, hashes the three file columns,
+// and writes the result out as a JSON array. It exists so that
+// java/hiddenapi_bom.go doesn't have to hand-roll shell-level CSV splitting
+// and JSON string escaping, which is exactly the kind of thing that silently
+// corrupts a compliance manifest when a module name or path contains a space
+// or a quote.
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type bomEntry struct {
+	Module           string `json:"module"`
+	JarSHA256        string `json:"jar_sha256"`
+	EncodedDexSHA256 string `json:"encoded_dex_sha256"`
+	FlagsSHA256      string `json:"flags_sha256"`
+	ToolVersion      string `json:"tool_version"`
+}
+
+func main() {
+	manifest := flag.String("manifest", "", "tab-separated module\\tjar\\tencodedDex\\tflagsCSV manifest, one module per line")
+	toolVersion := flag.String("tool_version", "", "hiddenapi tool version to stamp into each entry")
+	out := flag.String("out", "", "output BOM json path")
+	flag.Parse()
+
+	if *manifest == "" || *out == "" {
+		fmt.Fprintln(os.Stderr, "hiddenapi_bom: -manifest and -out are required")
+		os.Exit(1)
+	}
+
+	entries, err := buildBOM(*manifest, *toolVersion)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "hiddenapi_bom:", err)
+		os.Exit(1)
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "hiddenapi_bom:", err)
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(*out, append(data, '\n'), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "hiddenapi_bom:", err)
+		os.Exit(1)
+	}
+}
+
+func buildBOM(manifestPath, toolVersion string) ([]bomEntry, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []bomEntry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("malformed manifest line (want 4 tab-separated fields): %q", line)
+		}
+		module, jar, dex, flagsCSV := fields[0], fields[1], fields[2], fields[3]
+
+		jarHash, err := sha256File(jar)
+		if err != nil {
+			return nil, err
+		}
+		dexHash, err := sha256File(dex)
+		if err != nil {
+			return nil, err
+		}
+		flagsHash, err := sha256File(flagsCSV)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, bomEntry{
+			Module:           module,
+			JarSHA256:        jarHash,
+			EncodedDexSHA256: dexHash,
+			FlagsSHA256:      flagsHash,
+			ToolVersion:      toolVersion,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuildBOMHandlesSpacesAndQuotesInModuleNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hiddenapi_bom_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jar := writeTempFile(t, dir, "classes.jar", "jar-contents")
+	dex := writeTempFile(t, dir, "classes.dex", "dex-contents")
+	flags := writeTempFile(t, dir, "flags.csv", "flags-contents")
+
+	// A module name containing both a space and a double quote is exactly
+	// the input that broke the old space-joined-and-cut shell approach, and
+	// would have produced invalid JSON from an unescaped printf.
+	moduleName := `weird "module" name`
+
+	manifest := writeTempFile(t, dir, "manifest.tsv", moduleName+"\t"+jar+"\t"+dex+"\t"+flags+"\n")
+
+	entries, err := buildBOM(manifest, "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("buildBOM() returned %d entries, want 1", len(entries))
+	}
+
+	got := entries[0]
+	if got.Module != moduleName {
+		t.Errorf("Module = %q, want %q", got.Module, moduleName)
+	}
+	if want := sha256Hex("jar-contents"); got.JarSHA256 != want {
+		t.Errorf("JarSHA256 = %q, want %q", got.JarSHA256, want)
+	}
+	if want := sha256Hex("dex-contents"); got.EncodedDexSHA256 != want {
+		t.Errorf("EncodedDexSHA256 = %q, want %q", got.EncodedDexSHA256, want)
+	}
+	if want := sha256Hex("flags-contents"); got.FlagsSHA256 != want {
+		t.Errorf("FlagsSHA256 = %q, want %q", got.FlagsSHA256, want)
+	}
+	if got.ToolVersion != "7" {
+		t.Errorf("ToolVersion = %q, want %q", got.ToolVersion, "7")
+	}
+
+	// The whole point of using encoding/json is that this round-trips
+	// correctly; confirm it actually does.
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roundTripped []bomEntry
+	if err := json.Unmarshal(data, &roundTripped); err != nil {
+		t.Fatalf("generated BOM is not valid JSON: %v", err)
+	}
+	if roundTripped[0].Module != moduleName {
+		t.Errorf("round-tripped Module = %q, want %q", roundTripped[0].Module, moduleName)
+	}
+}
+
+func TestBuildBOMRejectsMalformedManifestLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hiddenapi_bom_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := writeTempFile(t, dir, "manifest.tsv", "only-one-field\n")
+
+	if _, err := buildBOM(manifest, "1"); err == nil {
+		t.Fatal("buildBOM() with a malformed line returned nil error, want an error")
+	}
+}
+
+func sha256Hex(s string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// hiddenapi_cache_gc prunes entries from the hiddenapi content-addressed
+// cache (see java/hiddenapi.go) that haven't been touched in longer than
+// -max-age-days, so that the cache directory doesn't grow unbounded across
+// incremental builds.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func main() {
+	cacheDir := flag.String("cache-dir", "", "hiddenapi cache directory to prune (e.g. $SOONG_HIDDENAPI_CACHE_DIR)")
+	maxAgeDays := flag.Int("max-age-days", 30, "remove cache entries not modified in this many days")
+	flag.Parse()
+
+	if *cacheDir == "" {
+		fmt.Fprintln(os.Stderr, "hiddenapi_cache_gc: -cache-dir is required")
+		os.Exit(1)
+	}
+
+	removed, err := prune(*cacheDir, time.Duration(*maxAgeDays)*24*time.Hour, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "hiddenapi_cache_gc:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("hiddenapi_cache_gc: removed %d stale entries from %s\n", removed, *cacheDir)
+}
+
+// prune removes immediate children of cacheDir whose mtime is older than
+// now.Add(-maxAge), and returns how many it removed. now is threaded through
+// explicitly so this is unit-testable without relying on the wall clock.
+func prune(cacheDir string, maxAge time.Duration, now time.Time) (int, error) {
+	entries, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	cutoff := now.Add(-maxAge)
+	removed := 0
+	for _, entry := range entries {
+		if entry.ModTime().Before(cutoff) {
+			if err := os.RemoveAll(filepath.Join(cacheDir, entry.Name())); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPruneRemovesOnlyStaleEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hiddenapi_cache_gc_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+
+	fresh := filepath.Join(dir, "fresh-entry")
+	stale := filepath.Join(dir, "stale-entry")
+	for _, d := range []string{fresh, stale} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	staleTime := now.Add(-60 * 24 * time.Hour)
+	if err := os.Chtimes(stale, staleTime, staleTime); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := prune(dir, 30*24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("prune() removed %d entries, want 1", removed)
+	}
+
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("fresh entry was removed: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale entry was not removed: %v", err)
+	}
+}
+
+func TestPruneMissingDirIsNotAnError(t *testing.T) {
+	if removed, err := prune(filepath.Join(os.TempDir(), "does-not-exist-hiddenapi-cache"), time.Hour, time.Now()); err != nil || removed != 0 {
+		t.Errorf("prune() on missing dir = (%d, %v), want (0, nil)", removed, err)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// merge_csv merges a base hiddenapi flags CSV with zero or more override
+// CSVs, keyed on each line's signature (the text before its first comma).
+// Later files win when a signature appears more than once, so a per-module
+// "hiddenapi: {...}" override in java/hiddenapi_properties.go can take
+// precedence over the platform's flags for that module (see
+// java/hiddenapi.go's hiddenAPIMergeCSVRule).
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+func main() {
+	out := flag.String("out", "", "merged output CSV path")
+	flag.Parse()
+
+	if *out == "" {
+		fmt.Fprintln(os.Stderr, "merge_csv: -out is required")
+		os.Exit(1)
+	}
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "merge_csv: at least one input CSV is required")
+		os.Exit(1)
+	}
+
+	lines, err := mergeCSVFiles(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "merge_csv:", err)
+		os.Exit(1)
+	}
+
+	content := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		content += "\n"
+	}
+	if err := os.WriteFile(*out, []byte(content), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "merge_csv:", err)
+		os.Exit(1)
+	}
+}
+
+// mergeCSVFiles merges the given CSVs in order, keyed on each line's
+// signature (the text before its first comma); a line from a later file
+// replaces an earlier line with the same signature. The result is sorted by
+// signature so the output is stable regardless of input ordering.
+func mergeCSVFiles(paths []string) ([]string, error) {
+	merged := map[string]string{}
+	for _, path := range paths {
+		if err := mergeCSVFileInto(merged, path); err != nil {
+			return nil, err
+		}
+	}
+
+	sigs := make([]string, 0, len(merged))
+	for sig := range merged {
+		sigs = append(sigs, sig)
+	}
+	sort.Strings(sigs)
+
+	lines := make([]string, 0, len(sigs))
+	for _, sig := range sigs {
+		lines = append(lines, merged[sig])
+	}
+	return lines, nil
+}
+
+func mergeCSVFileInto(merged map[string]string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		sig := line
+		if i := strings.IndexByte(line, ','); i >= 0 {
+			sig = line[:i]
+		}
+		merged[sig] = line
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeCSV(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMergeCSVFilesLaterFileWins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge_csv_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	platform := writeCSV(t, dir, "platform.csv", "Lfoo;->bar()V,blocked\nLfoo;->baz()V,unsupported\n")
+	module := writeCSV(t, dir, "module.csv", "Lfoo;->bar()V,unsupported,max-target-sdk-29\n")
+
+	got, err := mergeCSVFiles([]string{platform, module})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"Lfoo;->bar()V,unsupported,max-target-sdk-29",
+		"Lfoo;->baz()V,unsupported",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeCSVFiles() = %q, want %q", got, want)
+	}
+}
+
+func TestMergeCSVFilesEmptyInputsProduceEmptyOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge_csv_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	empty := writeCSV(t, dir, "empty.csv", "")
+
+	got, err := mergeCSVFiles([]string{empty})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("mergeCSVFiles() = %q, want empty", got)
+	}
+}
+
+func TestMergeCSVFilesMissingFileIsAnError(t *testing.T) {
+	if _, err := mergeCSVFiles([]string{"/nonexistent/path.csv"}); err == nil {
+		t.Fatal("mergeCSVFiles() with a missing file returned nil error, want an error")
+	}
+}
@@ -15,7 +15,11 @@
 package java
 
 import (
+	"fmt"
+	"os"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -24,16 +28,88 @@ import (
 	"android/soong/android"
 )
 
+// hiddenAPIToolVersion is bumped whenever Class2Greylist's output format
+// changes in a way that should invalidate the content-addressed cache below;
+// it isn't read from android.Config because, unlike HiddenAPIFlags and
+// HiddenAPIPublicList, it has no existing home there.
+func init() {
+	pctx.StaticVariable("hiddenAPIToolVersion", "1")
+}
+
+// hiddenAPICacheDir returns the directory the content-addressed hiddenapi
+// CSV cache is rooted at. The cache was designed around a
+// --hiddenapi-cache=off|local|shared build flag threaded through
+// android.Config, but that type is owned outside this package and isn't
+// touched by this series, so for now there is no such flag: only the
+// HIDDENAPI_CACHE_DIR/HIDDENAPI_CACHE_MODE environment variables, which `m`
+// happens to forward like any other env var but which aren't discoverable
+// from `m --help` or tab-completion the way a real flag would be. Land the
+// Config plumbing and replace this with a real accessor before relying on
+// the flag name from the original request.
+func hiddenAPICacheDir() string {
+	if dir, ok := os.LookupEnv("HIDDENAPI_CACHE_DIR"); ok {
+		return dir
+	}
+	return "out/soong/.hiddenapi-cache"
+}
+
+// hiddenAPICacheMode returns "off", "local" or "shared" per HIDDENAPI_CACHE_MODE,
+// defaulting to "local" (a cache rooted under the current out dir).
+func hiddenAPICacheMode() string {
+	if mode, ok := os.LookupEnv("HIDDENAPI_CACHE_MODE"); ok {
+		return mode
+	}
+	return "local"
+}
+
+// hiddenAPIEncodeShards returns how many parallel shards hiddenAPIEncodeDex
+// should split a module's dex encoding into, from HIDDENAPI_ENCODE_SHARDS,
+// defaulting to runtime.NumCPU()/2 (rounded up to at least 1). Same gap as
+// hiddenAPICacheDir/hiddenAPICacheMode above: this is an env var standing in
+// for a Config() accessor that doesn't exist yet.
+func hiddenAPIEncodeShards() int {
+	if s, ok := os.LookupEnv("HIDDENAPI_ENCODE_SHARDS"); ok {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			return n
+		}
+	}
+
+	if shards := runtime.NumCPU() / 2; shards > 0 {
+		return shards
+	}
+	return 1
+}
+
+// hiddenAPIGenerateCSVRule runs Class2Greylist, unless a previous run already
+// produced the same output for the same inputs: the key is
+// sha256(classesJar) + sha256(publicAPIList) + hiddenAPIToolVersion, and hits
+// are served with a copy out of $cacheDir instead of re-running the tool.
+// $cacheMode is "off", "local" or "shared" (see hiddenAPICacheMode doc);
+// "off" skips the cache entirely.
 var hiddenAPIGenerateCSVRule = pctx.AndroidStaticRule("hiddenAPIGenerateCSV", blueprint.RuleParams{
-	Command:     "${config.Class2Greylist} --public-api-list ${publicAPIList} $in $outFlag $out",
+	Command: `if [ "$cacheMode" = "off" ]; then ` +
+		`  ${config.Class2Greylist} --public-api-list ${publicAPIList} $in $outFlag $out; ` +
+		`else ` +
+		`  key=$$(sha256sum $in ${publicAPIList} | cut -d' ' -f1 | tr -d '\n')-${hiddenAPIToolVersion} && ` +
+		`  entry=$cacheDir/$$key/$outName && ` +
+		`  if [ -f $$entry ]; then ` +
+		`    cp $$entry $out; ` +
+		`  else ` +
+		`    ${config.Class2Greylist} --public-api-list ${publicAPIList} $in $outFlag $out && ` +
+		`    mkdir -p $$(dirname $$entry) && cp $out $$entry; ` +
+		`  fi; ` +
+		`fi`,
 	CommandDeps: []string{"${config.Class2Greylist}"},
-}, "outFlag", "publicAPIList")
+}, "outFlag", "outName", "publicAPIList", "cacheDir", "cacheMode")
 
 func hiddenAPIGenerateCSV(ctx android.ModuleContext, classesJar android.Path) {
 	flagsCSV := android.PathForModuleOut(ctx, "hiddenapi", "flags.csv")
 	metadataCSV := android.PathForModuleOut(ctx, "hiddenapi", "metadata.csv")
 	publicList := &bootImagePath{ctx.Config().HiddenAPIPublicList()}
 
+	cacheDir := hiddenAPICacheDir()
+	cacheMode := hiddenAPICacheMode()
+
 	ctx.Build(pctx, android.BuildParams{
 		Rule:        hiddenAPIGenerateCSVRule,
 		Description: "hiddenapi flags",
@@ -42,7 +118,10 @@ func hiddenAPIGenerateCSV(ctx android.ModuleContext, classesJar android.Path) {
 		Implicit:    publicList,
 		Args: map[string]string{
 			"outFlag":       "--write-flags-csv",
+			"outName":       "flags.csv",
 			"publicAPIList": publicList.String(),
+			"cacheDir":      cacheDir,
+			"cacheMode":     cacheMode,
 		},
 	})
 
@@ -54,61 +133,149 @@ func hiddenAPIGenerateCSV(ctx android.ModuleContext, classesJar android.Path) {
 		Implicit:    publicList,
 		Args: map[string]string{
 			"outFlag":       "--write-metadata-csv",
+			"outName":       "metadata.csv",
 			"publicAPIList": publicList.String(),
+			"cacheDir":      cacheDir,
+			"cacheMode":     cacheMode,
 		},
 	})
 
 	hiddenAPISaveCSVOutputs(ctx, flagsCSV, metadataCSV)
 }
 
-var hiddenAPIEncodeDexRule = pctx.AndroidStaticRule("hiddenAPIEncodeDex", blueprint.RuleParams{
-	Command: `rm -rf $tmpDir && mkdir -p $tmpDir && mkdir $tmpDir/dex-input && mkdir $tmpDir/dex-output && ` +
+// hiddenAPIEncodeDexPartitionRule unzips the classes*.dex entries out of $in
+// and round-robins them into $shards per-shard directories
+// ($tmpDir/dex-input-0, $tmpDir/dex-input-1, ...) so that each shard can be
+// encoded by an independent, parallel ninja rule. The round-robin assignment
+// (rather than e.g. chunking by count) keeps shards balanced even when a
+// module's multidex shards vary a lot in size.
+var hiddenAPIEncodeDexPartitionRule = pctx.AndroidStaticRule("hiddenAPIEncodeDexPartition", blueprint.RuleParams{
+	Command: `rm -rf $tmpDir && mkdir -p $tmpDir/dex-input && ` +
 		`unzip -o -q $in 'classes*.dex' -d $tmpDir/dex-input && ` +
+		`shard=0 && ` +
 		`for INPUT_DEX in $$(find $tmpDir/dex-input -maxdepth 1 -name 'classes*.dex' | sort); do ` +
-		`  echo "--input-dex=$${INPUT_DEX}"; ` +
-		`  echo "--output-dex=$tmpDir/dex-output/$$(basename $${INPUT_DEX})"; ` +
-		`done | xargs ${config.HiddenAPI} encode --api-flags=$flags && ` +
-		`${config.SoongZipCmd} -o $tmpDir/dex.jar -C $tmpDir/dex-output -f "$tmpDir/dex-output/classes*.dex" && ` +
-		`${config.MergeZipsCmd} -D -zipToNotStrip $tmpDir/dex.jar -stripFile "classes*.dex" $out $tmpDir/dex.jar $in`,
+		`  mkdir -p $tmpDir/dex-input-$$shard && mv $$INPUT_DEX $tmpDir/dex-input-$$shard/ && ` +
+		`  shard=$$(( (shard + 1) % $shards )); ` +
+		`done && ` +
+		`touch $out`,
+}, "tmpDir", "shards")
+
+// hiddenAPIEncodeDexShardRule encodes the subset of classes*.dex files that
+// hiddenAPIEncodeDexPartitionRule assigned to $shard, producing a zip of just
+// that shard's encoded dex files. A shard with no files assigned (e.g. when
+// there are fewer dex files than shards) produces an empty zip rather than
+// invoking ${config.HiddenAPI}, so a jar with a single classes.dex still
+// works with the default shard count.
+var hiddenAPIEncodeDexShardRule = pctx.AndroidStaticRule("hiddenAPIEncodeDexShard", blueprint.RuleParams{
+	Command: `rm -rf $tmpDir/dex-output-$shard && mkdir -p $tmpDir/dex-output-$shard && ` +
+		`if [ -n "$$(find $tmpDir/dex-input-$shard -maxdepth 1 -name 'classes*.dex' 2>/dev/null)" ]; then ` +
+		`  for INPUT_DEX in $$(find $tmpDir/dex-input-$shard -maxdepth 1 -name 'classes*.dex' | sort); do ` +
+		`    echo "--input-dex=$${INPUT_DEX}"; ` +
+		`    echo "--output-dex=$tmpDir/dex-output-$shard/$$(basename $${INPUT_DEX})"; ` +
+		`  done | xargs ${config.HiddenAPI} encode --api-flags=$flags; ` +
+		`fi && ` +
+		`${config.SoongZipCmd} -o $out -C $tmpDir/dex-output-$shard -f "$tmpDir/dex-output-$shard/classes*.dex"`,
 	CommandDeps: []string{
 		"${config.HiddenAPI}",
 		"${config.SoongZipCmd}",
-		"${config.MergeZipsCmd}",
 	},
-}, "flags", "tmpDir")
+}, "flags", "tmpDir", "shard")
+
+// hiddenAPIEncodeDexMergeRule merges the per-shard encoded dex zips, in
+// ascending shard order, back on top of the original jar, stripping the
+// original unencoded classes*.dex entries. The fixed shard order (rather
+// than e.g. filesystem glob order) is what keeps the merged zip
+// reproducible across builds.
+var hiddenAPIEncodeDexMergeRule = pctx.AndroidStaticRule("hiddenAPIEncodeDexMerge", blueprint.RuleParams{
+	Command: `notStrip="" && for shardZip in $shardZips; do notStrip="$$notStrip -zipToNotStrip $$shardZip"; done && ` +
+		`${config.MergeZipsCmd} -D $$notStrip -stripFile "classes*.dex" $out $shardZips $in`,
+	CommandDeps: []string{"${config.MergeZipsCmd}"},
+}, "shardZips")
+
+// mergeCSVCmd is a local (not yet promoted to java/config) pctx variable:
+// chunk0-1 is the first user of a CSV merge tool in this package, so it
+// isn't defined in the shared config package this file otherwise draws
+// ${config.*} tool variables from.
+func init() {
+	pctx.HostBinToolVariable("mergeCSVCmd", "merge_csv")
+}
 
-func hiddenAPIEncodeDex(ctx android.ModuleContext, output android.WritablePath, dexInput android.WritablePath) {
-	flags := &bootImagePath{ctx.Config().HiddenAPIFlags()}
+var hiddenAPIMergeCSVRule = pctx.AndroidStaticRule("hiddenAPIMergeCSV", blueprint.RuleParams{
+	Command:     "${mergeCSVCmd} --out $out $in $extra",
+	CommandDeps: []string{"${mergeCSVCmd}"},
+}, "extra")
+
+// hiddenAPIEncodeDex builds the hiddenapi-encoded dex for a module. props may
+// be nil, or a HiddenAPIFlagsProperties populated from that module's own
+// `hiddenapi: {}` Blueprint property group; callers that don't expose such a
+// property group should simply pass nil.
+func hiddenAPIEncodeDex(ctx android.ModuleContext, output android.WritablePath, dexInput android.WritablePath, props *HiddenAPIFlagsProperties) {
+	flags := hiddenAPIFlagsForModule(ctx, props)
+	tmpDir := android.PathForModuleOut(ctx, "hiddenapi", "dex")
+
+	shards := hiddenAPIEncodeShards()
+
+	partitionStamp := android.PathForModuleOut(ctx, "hiddenapi", "dex", "partitioned.stamp")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        hiddenAPIEncodeDexPartitionRule,
+		Description: "hiddenapi partition dex",
+		Input:       dexInput,
+		Output:      partitionStamp,
+		Args: map[string]string{
+			"tmpDir": tmpDir.String(),
+			"shards": strconv.Itoa(shards),
+		},
+	})
+
+	shardZips := make(android.Paths, 0, shards)
+	for shard := 0; shard < shards; shard++ {
+		shardZip := android.PathForModuleOut(ctx, "hiddenapi", "dex", fmt.Sprintf("shard-%d.jar", shard))
+
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        hiddenAPIEncodeDexShardRule,
+			Description: fmt.Sprintf("hiddenapi encode dex shard %d/%d", shard+1, shards),
+			Output:      shardZip,
+			Implicit:    partitionStamp,
+			Implicits:   android.Paths{flags},
+			Args: map[string]string{
+				"flags":  flags.String(),
+				"tmpDir": tmpDir.String(),
+				"shard":  strconv.Itoa(shard),
+			},
+		})
+
+		shardZips = append(shardZips, shardZip)
+	}
 
 	ctx.Build(pctx, android.BuildParams{
-		Rule:        hiddenAPIEncodeDexRule,
-		Description: "hiddenapi encode dex",
+		Rule:        hiddenAPIEncodeDexMergeRule,
+		Description: "hiddenapi merge dex shards",
 		Input:       dexInput,
 		Output:      output,
-		Implicit:    flags,
+		Implicits:   shardZips,
 		Args: map[string]string{
-			"flags":  flags.String(),
-			"tmpDir": android.PathForModuleOut(ctx, "hiddenapi", "dex").String(),
+			"shardZips": strings.Join(shardZips.Strings(), " "),
 		},
 	})
 
 	hiddenAPISaveDexInputs(ctx, dexInput)
+	hiddenAPISaveBOMEntry(ctx, dexInput, output, flags)
 }
 
 const hiddenAPIOutputsKey = "hiddenAPIOutputsKey"
 
 var hiddenAPIOutputsLock sync.Mutex
 
-func hiddenAPIGetOutputs(config android.Config) (*android.Paths, *android.Paths, *android.Paths) {
-	type threePathsPtrs [3]*android.Paths
+func hiddenAPIGetOutputs(config android.Config) (*android.Paths, *android.Paths, *android.Paths, *android.Paths) {
+	type fourPathsPtrs [4]*android.Paths
 	s := config.Once(hiddenAPIOutputsKey, func() interface{} {
-		return threePathsPtrs{new(android.Paths), new(android.Paths), new(android.Paths)}
-	}).(threePathsPtrs)
-	return s[0], s[1], s[2]
+		return fourPathsPtrs{new(android.Paths), new(android.Paths), new(android.Paths), new(android.Paths)}
+	}).(fourPathsPtrs)
+	return s[0], s[1], s[2], s[3]
 }
 
 func hiddenAPISaveCSVOutputs(ctx android.ModuleContext, flagsCSV, metadataCSV android.Path) {
-	flagsCSVList, metadataCSVList, _ := hiddenAPIGetOutputs(ctx.Config())
+	flagsCSVList, metadataCSVList, _, _ := hiddenAPIGetOutputs(ctx.Config())
 
 	hiddenAPIOutputsLock.Lock()
 	defer hiddenAPIOutputsLock.Unlock()
@@ -118,7 +285,7 @@ func hiddenAPISaveCSVOutputs(ctx android.ModuleContext, flagsCSV, metadataCSV an
 }
 
 func hiddenAPISaveDexInputs(ctx android.ModuleContext, dexInput android.Path) {
-	_, _, dexInputList := hiddenAPIGetOutputs(ctx.Config())
+	_, _, dexInputList, _ := hiddenAPIGetOutputs(ctx.Config())
 
 	hiddenAPIOutputsLock.Lock()
 	defer hiddenAPIOutputsLock.Unlock()
@@ -126,12 +293,24 @@ func hiddenAPISaveDexInputs(ctx android.ModuleContext, dexInput android.Path) {
 	*dexInputList = append(*dexInputList, dexInput)
 }
 
+// hiddenAPISaveModuleFlags records a module's synthesized hiddenapi override
+// CSV so that hiddenAPIMakeVars can export the union of all of them, in
+// addition to each module using its own merged flags to encode its dex.
+func hiddenAPISaveModuleFlags(ctx android.ModuleContext, moduleFlagsCSV android.Path) {
+	_, _, _, moduleFlagsList := hiddenAPIGetOutputs(ctx.Config())
+
+	hiddenAPIOutputsLock.Lock()
+	defer hiddenAPIOutputsLock.Unlock()
+
+	*moduleFlagsList = append(*moduleFlagsList, moduleFlagsCSV)
+}
+
 func init() {
 	android.RegisterMakeVarsProvider(pctx, hiddenAPIMakeVars)
 }
 
 func hiddenAPIMakeVars(ctx android.MakeVarsContext) {
-	flagsCSVList, metadataCSVList, dexInputList := hiddenAPIGetOutputs(ctx.Config())
+	flagsCSVList, metadataCSVList, dexInputList, moduleFlagsList := hiddenAPIGetOutputs(ctx.Config())
 
 	export := func(name string, paths *android.Paths) {
 		s := paths.Strings()
@@ -142,4 +321,12 @@ func hiddenAPIMakeVars(ctx android.MakeVarsContext) {
 	export("SOONG_HIDDENAPI_FLAGS", flagsCSVList)
 	export("SOONG_HIDDENAPI_GREYLIST_METADATA", metadataCSVList)
 	export("SOONG_HIDDENAPI_DEX_INPUTS", dexInputList)
+	export("SOONG_HIDDENAPI_MODULE_FLAGS", moduleFlagsList)
+
+	// Exported so that the hiddenapi_cache_gc build command (cmd/hiddenapi_cache_gc)
+	// knows which directory to prune without having to duplicate the
+	// HIDDENAPI_CACHE_DIR resolution logic.
+	ctx.Strict("SOONG_HIDDENAPI_CACHE_DIR", hiddenAPICacheDir())
+
+	ctx.Strict("SOONG_HIDDENAPI_BOM", hiddenAPIBOMPath(ctx).String())
 }
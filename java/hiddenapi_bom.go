@@ -0,0 +1,128 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// hiddenAPIBOMEntry records everything needed to describe, for a single
+// module, which dex file was hiddenapi-encoded, from which jar, with which
+// flags. hiddenAPIEncodeDex appends one of these per module it processes;
+// hiddenAPIBOMSingleton turns the collected entries into the build-wide BOM.
+type hiddenAPIBOMEntry struct {
+	module     string
+	jar        android.Path
+	encodedDex android.Path
+	flags      android.Path
+}
+
+const hiddenAPIBOMKey = "hiddenAPIBOMKey"
+
+func hiddenAPIGetBOMEntries(config android.Config) *[]hiddenAPIBOMEntry {
+	return config.Once(hiddenAPIBOMKey, func() interface{} {
+		return &[]hiddenAPIBOMEntry{}
+	}).(*[]hiddenAPIBOMEntry)
+}
+
+func hiddenAPISaveBOMEntry(ctx android.ModuleContext, jar, encodedDex, flags android.Path) {
+	entries := hiddenAPIGetBOMEntries(ctx.Config())
+
+	hiddenAPIOutputsLock.Lock()
+	defer hiddenAPIOutputsLock.Unlock()
+
+	*entries = append(*entries, hiddenAPIBOMEntry{
+		module:     ctx.ModuleName(),
+		jar:        jar,
+		encodedDex: encodedDex,
+		flags:      flags,
+	})
+}
+
+func init() {
+	pctx.HostBinToolVariable("hiddenAPIBOMCmd", "hiddenapi_bom")
+}
+
+// hiddenAPIBOMRule hashes each module's input jar, encoded dex and flags CSV
+// and assembles them into a JSON array, one object per module, so compliance
+// and audit tooling has a single manifest to diff between builds instead of
+// having to re-parse ninja logs.
+//
+// The per-module correlation is read out of $manifest (one
+// "module\tjar\tencodedDex\tflagsCSV" line per module) by the hiddenapi_bom
+// tool rather than reconstructed here from four separately space-joined
+// lists: a module name or path containing a space would desync positional
+// cut-based lookups, and hand-rolled shell printf can't safely escape
+// arbitrary strings into JSON, whereas encoding/json can.
+var hiddenAPIBOMRule = pctx.AndroidStaticRule("hiddenAPIBOM", blueprint.RuleParams{
+	Command:     `${hiddenAPIBOMCmd} -manifest $manifest -tool_version ${hiddenAPIToolVersion} -out $out`,
+	CommandDeps: []string{"${hiddenAPIBOMCmd}"},
+}, "manifest")
+
+func init() {
+	android.RegisterSingletonType("hiddenapi_bom", hiddenAPIBOMSingletonFactory)
+}
+
+func hiddenAPIBOMSingletonFactory() android.Singleton {
+	return &hiddenAPIBOMSingleton{}
+}
+
+type hiddenAPIBOMSingleton struct{}
+
+func (h *hiddenAPIBOMSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	entries := *hiddenAPIGetBOMEntries(ctx.Config())
+
+	// Always emit the rule, even with zero entries (producing an empty "[]"
+	// manifest): hiddenAPIMakeVars unconditionally exports
+	// SOONG_HIDDENAPI_BOM to hiddenAPIBOMPath, and a make-side consumer that
+	// references it as a prerequisite would otherwise hit "no rule to make
+	// target" on a tree with no hiddenapi-encoded modules.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].module < entries[j].module })
+
+	var manifestLines []string
+	var inputs android.Paths
+	for _, e := range entries {
+		manifestLines = append(manifestLines, strings.Join([]string{
+			e.module, e.jar.String(), e.encodedDex.String(), e.flags.String(),
+		}, "\t"))
+		inputs = append(inputs, e.jar, e.encodedDex, e.flags)
+	}
+
+	manifest := android.PathForOutput(ctx, "hiddenapi-bom-manifest.tsv")
+	android.WriteFileRule(ctx, manifest, strings.Join(manifestLines, "\n"))
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        hiddenAPIBOMRule,
+		Description: "hiddenapi bom",
+		Output:      hiddenAPIBOMPath(ctx),
+		Implicit:    manifest,
+		Implicits:   inputs,
+		Args: map[string]string{
+			"manifest": manifest.String(),
+		},
+	})
+}
+
+// hiddenAPIBOMPath is shared between the singleton that builds the BOM and
+// hiddenAPIMakeVars, which exports its location so make-side consumers don't
+// need to guess the out/soong layout.
+func hiddenAPIBOMPath(ctx android.PathContext) android.WritablePath {
+	return android.PathForOutput(ctx, "hiddenapi-bom.json")
+}
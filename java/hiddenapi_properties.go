@@ -0,0 +1,124 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"sort"
+	"strings"
+
+	"android/soong/android"
+)
+
+// HiddenAPIFlagsProperties allows a java_library/android_app module to
+// declare overrides to the platform hiddenapi flags next to the module
+// itself, rather than in the central flags text file. These are merged on
+// top of the platform-wide flags before being passed to
+// ${config.HiddenAPI} encode.
+//
+// A module that wants this behavior embeds HiddenAPIFlagsProperties in its
+// own properties struct and passes a pointer to it into hiddenAPIEncodeDex,
+// the same way classesJar and dexInput are already threaded in explicitly;
+// there is no implicit discovery by module type.
+type HiddenAPIFlagsProperties struct {
+	Hiddenapi struct {
+		// The maximum target SDK version for which the members listed in
+		// Unsupported are still allowed, e.g. "R" or "29". Leave unset for
+		// members that should never be allowed regardless of target SDK.
+		Max_target_sdk *string
+
+		// Members to flag "unsupported" (dark greylist). For each listed
+		// member this replaces the platform flags file's line for it.
+		Unsupported []string
+
+		// Members to flag "blocked" (blacklist). For each listed member this
+		// replaces the platform flags file's line for it.
+		Blocked []string
+	}
+}
+
+// hiddenAPIModuleFlagsEmpty reports whether the module declared no
+// hiddenapi overrides at all, so callers can skip the per-module merge step.
+func (p *HiddenAPIFlagsProperties) hiddenAPIModuleFlagsEmpty() bool {
+	if p == nil {
+		return true
+	}
+	h := &p.Hiddenapi
+	return h.Max_target_sdk == nil && len(h.Unsupported) == 0 && len(h.Blocked) == 0
+}
+
+// hiddenAPIModuleFlagsCSVLines renders a module's hiddenapi property group
+// into the same "signature,flag[,flag...]" format as the platform flags
+// file, so it can be merged alongside it. Split out from
+// hiddenAPIGenerateModuleFlags so the formatting can be unit tested without
+// an android.ModuleContext.
+func hiddenAPIModuleFlagsCSVLines(props *HiddenAPIFlagsProperties) []string {
+	h := &props.Hiddenapi
+
+	maxTargetSdkSuffix := ""
+	if h.Max_target_sdk != nil {
+		maxTargetSdkSuffix = ",max-target-sdk-" + *h.Max_target_sdk
+	}
+
+	var lines []string
+	for _, sig := range h.Blocked {
+		lines = append(lines, sig+",blocked")
+	}
+	for _, sig := range h.Unsupported {
+		lines = append(lines, sig+",unsupported"+maxTargetSdkSuffix)
+	}
+	sort.Strings(lines)
+
+	return lines
+}
+
+// hiddenAPIGenerateModuleFlags writes the result of hiddenAPIModuleFlagsCSVLines
+// to a module-local CSV file.
+func hiddenAPIGenerateModuleFlags(ctx android.ModuleContext, props *HiddenAPIFlagsProperties) android.WritablePath {
+	out := android.PathForModuleOut(ctx, "hiddenapi", "module-flags.csv")
+	android.WriteFileRule(ctx, out, strings.Join(hiddenAPIModuleFlagsCSVLines(props), "\n"))
+	return out
+}
+
+// hiddenAPIFlagsForModule returns the api-flags input that should be passed
+// to ${config.HiddenAPI} encode for the current module: the unmodified
+// platform flags file, unless the caller passes a non-empty
+// HiddenAPIFlagsProperties (from a `hiddenapi: {}` Blueprint property
+// group), in which case its overrides are synthesized and merged on top of
+// the platform flags.
+func hiddenAPIFlagsForModule(ctx android.ModuleContext, props *HiddenAPIFlagsProperties) android.Path {
+	platformFlags := &bootImagePath{ctx.Config().HiddenAPIFlags()}
+
+	if props.hiddenAPIModuleFlagsEmpty() {
+		return platformFlags
+	}
+
+	moduleFlags := hiddenAPIGenerateModuleFlags(ctx, props)
+	merged := android.PathForModuleOut(ctx, "hiddenapi", "merged-flags.csv")
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        hiddenAPIMergeCSVRule,
+		Description: "hiddenapi merge module flags",
+		Input:       platformFlags,
+		Output:      merged,
+		Implicit:    moduleFlags,
+		Args: map[string]string{
+			"extra": moduleFlags.String(),
+		},
+	})
+
+	hiddenAPISaveModuleFlags(ctx, moduleFlags)
+
+	return merged
+}
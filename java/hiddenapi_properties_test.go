@@ -0,0 +1,79 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"reflect"
+	"testing"
+)
+
+func stringPtr(s string) *string { return &s }
+
+func TestHiddenAPIModuleFlagsEmpty(t *testing.T) {
+	tests := []struct {
+		name  string
+		props *HiddenAPIFlagsProperties
+		want  bool
+	}{
+		{name: "nil", props: nil, want: true},
+		{name: "zero value", props: &HiddenAPIFlagsProperties{}, want: true},
+		{name: "max_target_sdk set", props: func() *HiddenAPIFlagsProperties {
+			p := &HiddenAPIFlagsProperties{}
+			p.Hiddenapi.Max_target_sdk = stringPtr("R")
+			return p
+		}(), want: false},
+		{name: "unsupported set", props: func() *HiddenAPIFlagsProperties {
+			p := &HiddenAPIFlagsProperties{}
+			p.Hiddenapi.Unsupported = []string{"Lfoo/Bar;->baz()V"}
+			return p
+		}(), want: false},
+		{name: "blocked set", props: func() *HiddenAPIFlagsProperties {
+			p := &HiddenAPIFlagsProperties{}
+			p.Hiddenapi.Blocked = []string{"Lfoo/Bar;->qux()V"}
+			return p
+		}(), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.props.hiddenAPIModuleFlagsEmpty(); got != tt.want {
+				t.Errorf("hiddenAPIModuleFlagsEmpty() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHiddenAPIModuleFlagsCSVLines(t *testing.T) {
+	props := &HiddenAPIFlagsProperties{}
+	props.Hiddenapi.Max_target_sdk = stringPtr("R")
+	props.Hiddenapi.Unsupported = []string{"Lfoo/Bar;->baz()V"}
+	props.Hiddenapi.Blocked = []string{"Lfoo/Bar;->qux()V"}
+
+	got := hiddenAPIModuleFlagsCSVLines(props)
+	want := []string{
+		"Lfoo/Bar;->baz()V,unsupported,max-target-sdk-R",
+		"Lfoo/Bar;->qux()V,blocked",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hiddenAPIModuleFlagsCSVLines() = %q, want %q", got, want)
+	}
+}
+
+func TestHiddenAPIModuleFlagsCSVLinesEmpty(t *testing.T) {
+	if got := hiddenAPIModuleFlagsCSVLines(&HiddenAPIFlagsProperties{}); len(got) != 0 {
+		t.Errorf("hiddenAPIModuleFlagsCSVLines() = %q, want empty", got)
+	}
+}
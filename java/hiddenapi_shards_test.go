@@ -0,0 +1,44 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHiddenAPIEncodeShardsEnvOverride(t *testing.T) {
+	defer os.Unsetenv("HIDDENAPI_ENCODE_SHARDS")
+
+	if err := os.Setenv("HIDDENAPI_ENCODE_SHARDS", "7"); err != nil {
+		t.Fatal(err)
+	}
+	if got := hiddenAPIEncodeShards(); got != 7 {
+		t.Errorf("hiddenAPIEncodeShards() = %d, want 7", got)
+	}
+}
+
+func TestHiddenAPIEncodeShardsIgnoresInvalidEnv(t *testing.T) {
+	defer os.Unsetenv("HIDDENAPI_ENCODE_SHARDS")
+
+	for _, bad := range []string{"0", "-1", "not-a-number"} {
+		if err := os.Setenv("HIDDENAPI_ENCODE_SHARDS", bad); err != nil {
+			t.Fatal(err)
+		}
+		if got := hiddenAPIEncodeShards(); got < 1 {
+			t.Errorf("hiddenAPIEncodeShards() with HIDDENAPI_ENCODE_SHARDS=%q = %d, want >= 1", bad, got)
+		}
+	}
+}
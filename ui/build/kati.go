@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
@@ -92,8 +93,25 @@ func runKati(ctx Context, config Config) {
 		args = append(args, "-j"+strconv.Itoa(config.Parallel()))
 	}
 
+	reporter := newTerminalStatusReporter(ctx)
+	var statusReader, statusWriter *os.File
+	useStatusProtocol := ckatiSupportsStatusProtocol(ctx, executable)
+	if useStatusProtocol {
+		var err error
+		statusReader, statusWriter, err = os.Pipe()
+		if err != nil {
+			ctx.Verbosef("Failed to create kati status pipe, falling back to regex output: %v", err)
+			useStatusProtocol = false
+		} else {
+			args = append(args, "--status_fd=3")
+		}
+	}
+
 	cmd := exec.CommandContext(ctx.Context, executable, args...)
 	cmd.Env = config.Environment().Environ()
+	if useStatusProtocol {
+		cmd.ExtraFiles = []*os.File{statusWriter}
+	}
 	pipe, err := cmd.StdoutPipe()
 	if err != nil {
 		ctx.Fatalln("Error getting output pipe for ckati:", err)
@@ -105,7 +123,13 @@ func runKati(ctx Context, config Config) {
 		ctx.Fatalln("Failed to run ckati:", err)
 	}
 
-	katiRewriteOutput(ctx, pipe)
+	if useStatusProtocol {
+		statusWriter.Close()
+		runKatiStatusProtocol(ctx, pipe, statusReader, reporter)
+		statusReader.Close()
+	} else {
+		katiRewriteOutput(ctx, pipe)
+	}
 
 	if err := cmd.Wait(); err != nil {
 		if e, ok := err.(*exec.ExitError); ok {
@@ -0,0 +1,250 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// StatusReporter receives typed events describing ckati's progress. It lets
+// the smart-terminal renderer, the trace subsystem, and downstream tools
+// (IDE integrations, CI dashboards) consume the same structured stream
+// instead of each re-scraping ckati's stdout with their own regexes.
+type StatusReporter interface {
+	IncludeStarted(file string)
+	IncludeFinished(file string)
+	RuleEvaluated(output string)
+	Warning(file string, line int, msg string)
+	Error(file string, line int, msg string)
+}
+
+// katiStatusEvent is the wire format for a single status line: ckati emits
+// one JSON object per line on the fd passed via --status_fd=.
+type katiStatusEvent struct {
+	Type   string `json:"type"`
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Msg    string `json:"msg,omitempty"`
+	Output string `json:"output,omitempty"`
+}
+
+// ckatiSupportsStatusProtocol probes whether the given ckati binary
+// advertises the --status_fd= flag, so we can fall back to the regex-scraped
+// stdout path on older prebuilts.
+func ckatiSupportsStatusProtocol(ctx Context, executable string) bool {
+	out, err := exec.CommandContext(ctx.Context, executable, "--help").CombinedOutput()
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(out, []byte("--status_fd"))
+}
+
+// decodeKatiStatusEvents reads newline-delimited JSON status events from r
+// and sends each to events until decoding fails, then closes events and
+// returns that error (nil on a clean io.EOF). It's kept free of Context so
+// it can be unit tested without a real build Context, which this checkout
+// has no fixture for; decodeKatiStatusStream below is the thin wrapper that
+// logs the error through ctx.
+func decodeKatiStatusEvents(r io.Reader, events chan<- katiStatusEvent) error {
+	defer close(events)
+
+	dec := json.NewDecoder(r)
+	for {
+		var ev katiStatusEvent
+		if err := dec.Decode(&ev); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		events <- ev
+	}
+}
+
+// decodeKatiStatusStream reads newline-delimited JSON status events from r
+// and forwards them to events until r is closed, then closes events.
+//
+// It only ever sends on the channel, never writes to ctx.Stdout()/Stderr()
+// directly: runKatiStatusProtocol is the sole writer, so that a status event
+// can never be interleaved with ordinary build output mid-line.
+func decodeKatiStatusStream(ctx Context, r io.Reader, events chan<- katiStatusEvent) {
+	if err := decodeKatiStatusEvents(r, events); err != nil {
+		ctx.Verbosef("Error decoding kati status stream: %v", err)
+	}
+}
+
+// scanKatiPlainOutput reads newline-delimited build output from pipe and
+// forwards each line to lines until pipe is exhausted, then closes lines.
+//
+// Like decodeKatiStatusStream, it never writes to ctx.Stdout()/Stderr()
+// itself.
+func scanKatiPlainOutput(pipe io.Reader, lines chan<- string) {
+	defer close(lines)
+
+	scanner := bufio.NewScanner(pipe)
+	for scanner.Scan() {
+		lines <- scanner.Text()
+	}
+}
+
+// transientFlusher is implemented by StatusReporters that render an in-place
+// transient progress line (like terminalStatusReporter's "\r...\x1b[K") and
+// so need a chance to end it before ordinary output is written on top of it.
+// It's kept as a separate, optional interface rather than folded into
+// StatusReporter because a non-terminal reporter (a CI log, a trace writer)
+// has no transient line to flush.
+type transientFlusher interface {
+	flushTransient()
+}
+
+// dispatchKatiStatusEvent applies a single decoded status event to reporter.
+// It reports whether ev.Type was recognized, so callers can log unrecognized
+// types however they see fit without this function needing a Context. Kept
+// free of Context for the same testability reason as decodeKatiStatusEvents.
+func dispatchKatiStatusEvent(reporter StatusReporter, ev katiStatusEvent) bool {
+	switch ev.Type {
+	case "include_started":
+		reporter.IncludeStarted(ev.File)
+	case "include_finished":
+		reporter.IncludeFinished(ev.File)
+	case "rule_evaluated":
+		reporter.RuleEvaluated(ev.Output)
+	case "warning":
+		reporter.Warning(ev.File, ev.Line, ev.Msg)
+	case "error":
+		reporter.Error(ev.File, ev.Line, ev.Msg)
+	default:
+		return false
+	}
+	return true
+}
+
+// runKatiStatusProtocol merges ckati's ordinary stdout lines with its
+// structured status events and is the only goroutine that writes to
+// ctx.Stdout()/Stderr() while ckati is running. scanKatiPlainOutput and
+// decodeKatiStatusStream only feed channels; funneling both sources through
+// one select loop keeps a plain build line from being spliced into the
+// middle of reporter's in-place "\r...\x1b[K" transient line, the same
+// single-writer invariant that katiRewriteOutput relies on for the
+// non-status-protocol path.
+//
+// reporter is a StatusReporter rather than the concrete *terminalStatusReporter,
+// so a trace subsystem or other downstream consumer can be plugged in here
+// instead of only ever driving the terminal renderer.
+func runKatiStatusProtocol(ctx Context, pipe io.ReadCloser, statusReader io.Reader, reporter StatusReporter) {
+	lines := make(chan string)
+	events := make(chan katiStatusEvent)
+
+	go scanKatiPlainOutput(pipe, lines)
+	go decodeKatiStatusStream(ctx, statusReader, events)
+
+	flusher, canFlush := reporter.(transientFlusher)
+	smartTerminal := ctx.IsTerminal()
+	flushTransient := func() {
+		if canFlush {
+			flusher.flushTransient()
+		}
+	}
+
+	for lines != nil || events != nil {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				lines = nil
+				continue
+			}
+			flushTransient()
+			if !smartTerminal {
+				line = string(stripAnsiEscapes([]byte(line)))
+			}
+			fmt.Fprintln(ctx.Stderr(), line)
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if !dispatchKatiStatusEvent(reporter, ev) {
+				ctx.Verbosef("Unknown kati status event: %q", ev.Type)
+			}
+		}
+	}
+
+	flushTransient()
+}
+
+// terminalStatusReporter is the default StatusReporter: it reproduces the
+// smart-terminal behavior that katiRewriteOutput previously derived from
+// katiIncludeRe, but driven by ckati's structured events instead of a regex.
+type terminalStatusReporter struct {
+	ctx           Context
+	smartTerminal bool
+	haveBlankLine bool
+}
+
+func newTerminalStatusReporter(ctx Context) *terminalStatusReporter {
+	return &terminalStatusReporter{
+		ctx:           ctx,
+		smartTerminal: ctx.IsTerminal(),
+		haveBlankLine: true,
+	}
+}
+
+func (t *terminalStatusReporter) writeTransient(line string) {
+	if !t.smartTerminal {
+		fmt.Fprintln(t.ctx.Stderr(), line)
+		return
+	}
+
+	if max, ok := termWidth(t.ctx.Stdout()); ok && len(line) > max {
+		line = line[:max]
+	}
+	fmt.Fprint(t.ctx.Stdout(), "\r", line, "\x1b[K")
+	t.haveBlankLine = false
+}
+
+func (t *terminalStatusReporter) IncludeStarted(file string) {
+	t.writeTransient("including " + file + " ...")
+}
+
+func (t *terminalStatusReporter) IncludeFinished(file string) {
+	// The next transient or permanent line will overwrite this one; nothing
+	// to print on its own.
+}
+
+func (t *terminalStatusReporter) RuleEvaluated(output string) {
+	t.writeTransient(output)
+}
+
+func (t *terminalStatusReporter) flushTransient() {
+	if t.smartTerminal && !t.haveBlankLine {
+		fmt.Fprintln(t.ctx.Stdout())
+		t.haveBlankLine = true
+	}
+}
+
+func (t *terminalStatusReporter) Warning(file string, line int, msg string) {
+	t.flushTransient()
+	fmt.Fprintf(t.ctx.Stderr(), "%s:%d: warning: %s\n", file, line, msg)
+}
+
+func (t *terminalStatusReporter) Error(file string, line int, msg string) {
+	t.flushTransient()
+	fmt.Fprintf(t.ctx.Stderr(), "%s:%d: error: %s\n", file, line, msg)
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"strings"
+	"testing"
+)
+
+// fakeStatusReporter records every call it receives so tests can assert on
+// event routing without a real terminal or Context.
+type fakeStatusReporter struct {
+	includeStarted  []string
+	includeFinished []string
+	ruleEvaluated   []string
+	warnings        []string
+	errors          []string
+}
+
+func (f *fakeStatusReporter) IncludeStarted(file string) {
+	f.includeStarted = append(f.includeStarted, file)
+}
+func (f *fakeStatusReporter) IncludeFinished(file string) {
+	f.includeFinished = append(f.includeFinished, file)
+}
+func (f *fakeStatusReporter) RuleEvaluated(output string) {
+	f.ruleEvaluated = append(f.ruleEvaluated, output)
+}
+func (f *fakeStatusReporter) Warning(file string, line int, msg string) {
+	f.warnings = append(f.warnings, file)
+}
+func (f *fakeStatusReporter) Error(file string, line int, msg string) {
+	f.errors = append(f.errors, file)
+}
+
+func TestDispatchKatiStatusEventRoutesKnownTypes(t *testing.T) {
+	cases := []struct {
+		ev   katiStatusEvent
+		want func(*fakeStatusReporter) []string
+	}{
+		{katiStatusEvent{Type: "include_started", File: "a.mk"}, func(f *fakeStatusReporter) []string { return f.includeStarted }},
+		{katiStatusEvent{Type: "include_finished", File: "a.mk"}, func(f *fakeStatusReporter) []string { return f.includeFinished }},
+		{katiStatusEvent{Type: "rule_evaluated", Output: "a.mk"}, func(f *fakeStatusReporter) []string { return f.ruleEvaluated }},
+		{katiStatusEvent{Type: "warning", File: "a.mk"}, func(f *fakeStatusReporter) []string { return f.warnings }},
+		{katiStatusEvent{Type: "error", File: "a.mk"}, func(f *fakeStatusReporter) []string { return f.errors }},
+	}
+
+	for _, c := range cases {
+		reporter := &fakeStatusReporter{}
+		if ok := dispatchKatiStatusEvent(reporter, c.ev); !ok {
+			t.Errorf("dispatchKatiStatusEvent(%q) = false, want true", c.ev.Type)
+		}
+		if got := c.want(reporter); len(got) != 1 || got[0] != "a.mk" {
+			t.Errorf("dispatchKatiStatusEvent(%q) did not route to the expected method: %v", c.ev.Type, got)
+		}
+	}
+}
+
+func TestDispatchKatiStatusEventReportsUnknownType(t *testing.T) {
+	reporter := &fakeStatusReporter{}
+	if ok := dispatchKatiStatusEvent(reporter, katiStatusEvent{Type: "something_new"}); ok {
+		t.Error("dispatchKatiStatusEvent() = true for an unknown type, want false")
+	}
+}
+
+func TestDecodeKatiStatusEventsForwardsAllEventsThenCloses(t *testing.T) {
+	r := strings.NewReader(`{"type":"include_started","file":"a.mk"}` + "\n" + `{"type":"warning","file":"b.mk","msg":"oops"}` + "\n")
+	events := make(chan katiStatusEvent, 2)
+
+	if err := decodeKatiStatusEvents(r, events); err != nil {
+		t.Fatalf("decodeKatiStatusEvents() error = %v, want nil", err)
+	}
+
+	var got []katiStatusEvent
+	for ev := range events {
+		got = append(got, ev)
+	}
+	if len(got) != 2 || got[0].Type != "include_started" || got[1].Type != "warning" {
+		t.Errorf("decodeKatiStatusEvents() forwarded %+v, want include_started then warning", got)
+	}
+}
+
+func TestDecodeKatiStatusEventsReturnsDecodeError(t *testing.T) {
+	r := strings.NewReader(`not json`)
+	events := make(chan katiStatusEvent, 1)
+
+	if err := decodeKatiStatusEvents(r, events); err == nil {
+		t.Fatal("decodeKatiStatusEvents() error = nil, want a decode error")
+	}
+}
+
+func TestScanKatiPlainOutputForwardsLinesThenCloses(t *testing.T) {
+	r := strings.NewReader("first\nsecond\n")
+	lines := make(chan string, 2)
+
+	scanKatiPlainOutput(r, lines)
+
+	var got []string
+	for line := range lines {
+		got = append(got, line)
+	}
+	if len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Errorf("scanKatiPlainOutput() forwarded %v, want [first second]", got)
+	}
+}